@@ -0,0 +1,127 @@
+package profiler
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"time"
+)
+
+// httpServerShutdownTimeout bounds how long Stop waits for in-flight
+// requests against the on-demand profiling server to finish
+const httpServerShutdownTimeout = 5 * time.Second
+
+// startHTTPServer launches a background HTTP server exposing on-demand
+// profiles at /debug/pprof/*, mirroring net/http/pprof's handlers but
+// routed through this package's own Config instead of registering onto
+// http.DefaultServeMux, so it can run alongside the interval-based dumper
+func (p *profiler) startHTTPServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/profile", p.handleCPUProfile)
+	mux.HandleFunc("/debug/pprof/trace", p.handleTrace)
+	for _, kind := range []string{"heap", "block", "mutex", "goroutine"} {
+		mux.HandleFunc("/debug/pprof/"+kind, p.handleLookup(kind))
+	}
+
+	server := &http.Server{Addr: p.conf.HTTPAddr, Handler: mux}
+	p.mu.Lock()
+	p.httpServer = server
+	p.mu.Unlock()
+
+	p.logger.Println("Starting profiler HTTP server on", p.conf.HTTPAddr)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			p.logger.Println("profiler HTTP server stopped:", err)
+		}
+	}()
+}
+
+// shutdownHTTPServer gracefully stops server, so Stop followed by a
+// restarted Run doesn't try to bind an address that's still in use
+func shutdownHTTPServer(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpServerShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
+
+// handleLookup serves the named runtime/pprof profile, e.g. "heap" or "block"
+func (p *profiler) handleLookup(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			http.Error(w, "unknown profile: "+name, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := profile.WriteTo(w, 0); err != nil {
+			p.logger.Println(err)
+		}
+	}
+}
+
+// handleCPUProfile samples CPU for ?seconds= (default 30) and streams the
+// pprof profile. It claims the process-wide CPU profiler under p.mu before
+// starting, so it cannot race a continuous profile started by Run's
+// interval loop; if continuous CPU profiling already owns it, it reports
+// that instead of fighting runtime/pprof's single-profile restriction.
+func (p *profiler) handleCPUProfile(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	if p.cpuWriter != nil || p.cpuOnDemand {
+		p.mu.Unlock()
+		http.Error(w, "cpu profiling already in use", http.StatusServiceUnavailable)
+		return
+	}
+	p.cpuOnDemand = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.cpuOnDemand = false
+		p.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(secondsParam(r, 30))
+	pprof.StopCPUProfile()
+}
+
+// handleTrace captures a runtime/trace execution trace for ?seconds=
+// (default 1) and streams it. It claims the process-wide tracer under
+// p.mu before starting, mirroring handleCPUProfile's handling of a
+// continuous trace already in progress.
+func (p *profiler) handleTrace(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	if p.traceWriter != nil || p.traceOnDemand {
+		p.mu.Unlock()
+		http.Error(w, "execution tracing already in use", http.StatusServiceUnavailable)
+		return
+	}
+	p.traceOnDemand = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.traceOnDemand = false
+		p.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := trace.Start(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(secondsParam(r, 1))
+	trace.Stop()
+}
+
+func secondsParam(r *http.Request, def int) time.Duration {
+	sec, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || sec <= 0 {
+		sec = def
+	}
+	return time.Duration(sec) * time.Second
+}
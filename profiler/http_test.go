@@ -0,0 +1,53 @@
+package profiler
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleCPUProfileBusyWhenContinuousRunning(t *testing.T) {
+	p := newTestProfiler(newMemSink())
+	p.cpuWriter = discardWriteCloser{} // simulate continuous CPU profiling already running
+
+	w := httptest.NewRecorder()
+	p.handleCPUProfile(w, httptest.NewRequest("GET", "/debug/pprof/profile", nil))
+
+	if w.Code != 503 {
+		t.Fatalf("got status %d, want 503 when continuous CPU profiling owns the profiler", w.Code)
+	}
+}
+
+func TestHandleTraceBusyWhenContinuousRunning(t *testing.T) {
+	p := newTestProfiler(newMemSink())
+	p.traceWriter = discardWriteCloser{} // simulate continuous tracing already running
+
+	w := httptest.NewRecorder()
+	p.handleTrace(w, httptest.NewRequest("GET", "/debug/pprof/trace", nil))
+
+	if w.Code != 503 {
+		t.Fatalf("got status %d, want 503 when continuous tracing owns the tracer", w.Code)
+	}
+}
+
+func TestHandleCPUProfileRefusedLeavesOnDemandFlag(t *testing.T) {
+	p := newTestProfiler(newMemSink())
+	p.cpuOnDemand = true // simulate a second concurrent on-demand request
+
+	w := httptest.NewRecorder()
+	p.handleCPUProfile(w, httptest.NewRequest("GET", "/debug/pprof/profile?seconds=1", nil))
+
+	if w.Code != 503 {
+		t.Fatalf("got status %d, want 503 when another on-demand capture is in progress", w.Code)
+	}
+	if p.cpuOnDemand != true {
+		t.Fatal("expected the pre-existing on-demand flag to be left untouched by the refused request")
+	}
+}
+
+type discardWriteCloser struct{}
+
+func (discardWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriteCloser) Close() error                { return nil }
+
+var _ io.WriteCloser = discardWriteCloser{}
@@ -0,0 +1,186 @@
+package profiler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+)
+
+// collect Memory profiling information, optionally diffed against the
+// previous heap snapshot when Config.HeapDiff is set. The caller must
+// hold p.mu, since this reads and writes p.prevHeap via writeHeapDiff.
+func (p *profiler) takeMemorySnapshot() error {
+	p.logger.Println("Taking Memory Profile Snapshot")
+	var buf bytes.Buffer
+	if err := pprof.WriteHeapProfile(&buf); err != nil {
+		return fmt.Errorf("mem profile: %w", err)
+	}
+
+	w, err := p.sink.NewWriter("mem", time.Now())
+	var errs []error
+	if err != nil {
+		errs = append(errs, fmt.Errorf("mem profile: %w", err))
+	} else {
+		if _, err = w.Write(buf.Bytes()); err != nil {
+			errs = append(errs, err)
+		}
+		if err = w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	p.pruneSnapshots("mem")
+
+	if p.conf.HeapDiff {
+		if err := p.writeHeapDiff(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// heapSample is a diffable per-call-site allocation summary, built directly
+// from runtime.MemProfile rather than parsing a pprof-formatted heap dump
+type heapSample struct {
+	stack        string
+	allocObjects int64
+	allocBytes   int64
+	inuseObjects int64
+	inuseBytes   int64
+}
+
+// readHeapSamples captures the current heap profile as per-call-site
+// allocation counters, using the same runtime data runtime/pprof's heap
+// profile is built from
+func readHeapSamples() []heapSample {
+	var records []runtime.MemProfileRecord
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		records = make([]runtime.MemProfileRecord, n+16)
+		var ok bool
+		if n, ok = runtime.MemProfile(records, true); ok {
+			records = records[:n]
+			break
+		}
+	}
+
+	samples := make([]heapSample, 0, len(records))
+	for _, r := range records {
+		samples = append(samples, heapSample{
+			stack:        stackKey(r.Stack()),
+			allocObjects: r.AllocObjects,
+			allocBytes:   r.AllocBytes,
+			inuseObjects: r.InUseObjects(),
+			inuseBytes:   r.InUseBytes(),
+		})
+	}
+	return samples
+}
+
+// stackKey turns a call stack into a stable string key so two snapshots'
+// samples can be matched up by the call site that made the allocation
+func stackKey(stack []uintptr) string {
+	var b bytes.Buffer
+	for i, pc := range stack {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			file, line := fn.FileLine(pc)
+			fmt.Fprintf(&b, "%s %s:%d", fn.Name(), file, line)
+		} else {
+			fmt.Fprintf(&b, "0x%x", pc)
+		}
+	}
+	return b.String()
+}
+
+// writeHeapDiff computes and writes the per-call-site allocation delta
+// between the current heap profile and the one taken at the previous
+// snapshot, by subtracting sample counts/bytes directly rather than
+// post-processing two pprof dumps with `pprof -base`. The caller must
+// hold p.mu, since this reads and writes p.prevHeap.
+func (p *profiler) writeHeapDiff() error {
+	current := readHeapSamples()
+
+	var diffErr error
+	if p.prevHeap != nil {
+		diffErr = p.writeHeapDiffReport(diffHeapSamples(p.prevHeap, current))
+	}
+
+	p.prevHeap = current
+	return diffErr
+}
+
+// diffHeapSamples subtracts prev from cur per call stack, returning only
+// the stacks whose allocation counters changed, sorted by the biggest
+// growth in live bytes first
+func diffHeapSamples(prev, cur []heapSample) []heapSample {
+	prevByStack := make(map[string]heapSample, len(prev))
+	for _, s := range prev {
+		prevByStack[s.stack] = s
+	}
+
+	seen := make(map[string]bool, len(cur))
+	diffs := make([]heapSample, 0, len(cur))
+	for _, c := range cur {
+		seen[c.stack] = true
+		p := prevByStack[c.stack]
+		d := heapSample{
+			stack:        c.stack,
+			allocObjects: c.allocObjects - p.allocObjects,
+			allocBytes:   c.allocBytes - p.allocBytes,
+			inuseObjects: c.inuseObjects - p.inuseObjects,
+			inuseBytes:   c.inuseBytes - p.inuseBytes,
+		}
+		if d.allocObjects != 0 || d.allocBytes != 0 || d.inuseObjects != 0 || d.inuseBytes != 0 {
+			diffs = append(diffs, d)
+		}
+	}
+	for _, p := range prev {
+		if seen[p.stack] {
+			continue
+		}
+		// the stack existed before but not now: everything it held was freed
+		diffs = append(diffs, heapSample{
+			stack:        p.stack,
+			allocObjects: -p.allocObjects,
+			allocBytes:   -p.allocBytes,
+			inuseObjects: -p.inuseObjects,
+			inuseBytes:   -p.inuseBytes,
+		})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].inuseBytes > diffs[j].inuseBytes
+	})
+	return diffs
+}
+
+// writeHeapDiffReport writes a plain-text report of per-call-site
+// allocation deltas, one stack per entry, biggest growth in live bytes first
+func (p *profiler) writeHeapDiffReport(diffs []heapSample) error {
+	p.logger.Println("Taking Memory Diff Snapshot")
+	w, err := p.sink.NewWriter("mem-diff", time.Now())
+	if err != nil {
+		return fmt.Errorf("mem-diff: %w", err)
+	}
+
+	var errs []error
+	for _, d := range diffs {
+		if _, err := fmt.Fprintf(w, "%+d objects, %+d bytes in use (%+d objects, %+d bytes allocated)\n%s\n\n",
+			d.inuseObjects, d.inuseBytes, d.allocObjects, d.allocBytes, d.stack); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	if err := w.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	p.pruneSnapshots("mem-diff")
+	return errors.Join(errs...)
+}
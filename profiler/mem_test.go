@@ -0,0 +1,137 @@
+package profiler
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// memSink is an in-memory Sink used to assert on what writeHeapDiff writes
+// without touching the filesystem.
+type memSink struct {
+	buf map[string]*bytes.Buffer
+}
+
+func newMemSink() *memSink {
+	return &memSink{buf: make(map[string]*bytes.Buffer)}
+}
+
+func (s *memSink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	b := &bytes.Buffer{}
+	s.buf[kind] = b
+	return nopWriteCloser{b}, nil
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newTestProfiler(sink Sink) *profiler {
+	return &profiler{
+		conf:   Config{HeapDiff: true},
+		sink:   sink,
+		logger: log.New(io.Discard, "", 0),
+	}
+}
+
+func TestDiffHeapSamplesComputesDelta(t *testing.T) {
+	prev := []heapSample{
+		{stack: "a", allocObjects: 10, allocBytes: 1000, inuseObjects: 5, inuseBytes: 500},
+		{stack: "b", allocObjects: 3, allocBytes: 300, inuseObjects: 3, inuseBytes: 300},
+	}
+	cur := []heapSample{
+		{stack: "a", allocObjects: 20, allocBytes: 2000, inuseObjects: 8, inuseBytes: 800},
+		{stack: "c", allocObjects: 1, allocBytes: 100, inuseObjects: 1, inuseBytes: 100},
+	}
+
+	diffs := diffHeapSamples(prev, cur)
+
+	byStack := make(map[string]heapSample, len(diffs))
+	for _, d := range diffs {
+		byStack[d.stack] = d
+	}
+
+	a, ok := byStack["a"]
+	if !ok || a.allocObjects != 10 || a.allocBytes != 1000 || a.inuseObjects != 3 || a.inuseBytes != 300 {
+		t.Fatalf("got %+v, want the delta between a's two snapshots", a)
+	}
+	c, ok := byStack["c"]
+	if !ok || c.allocObjects != 1 || c.allocBytes != 100 || c.inuseObjects != 1 || c.inuseBytes != 100 {
+		t.Fatalf("got %+v, want c counted in full since it's new", c)
+	}
+	b, ok := byStack["b"]
+	if !ok || b.allocObjects != -3 || b.allocBytes != -300 || b.inuseObjects != -3 || b.inuseBytes != -300 {
+		t.Fatalf("got %+v, want b's counters negated since it no longer appears", b)
+	}
+}
+
+func TestDiffHeapSamplesOmitsUnchangedStacks(t *testing.T) {
+	same := []heapSample{{stack: "a", allocObjects: 1, allocBytes: 100, inuseObjects: 1, inuseBytes: 100}}
+
+	diffs := diffHeapSamples(same, same)
+
+	if len(diffs) != 0 {
+		t.Fatalf("got %d diffs, want 0 since nothing changed between snapshots", len(diffs))
+	}
+}
+
+func TestDiffHeapSamplesSortsByInuseBytesDescending(t *testing.T) {
+	prev := []heapSample{}
+	cur := []heapSample{
+		{stack: "small", inuseBytes: 100},
+		{stack: "big", inuseBytes: 900},
+		{stack: "medium", inuseBytes: 500},
+	}
+
+	diffs := diffHeapSamples(prev, cur)
+
+	if len(diffs) != 3 || diffs[0].stack != "big" || diffs[1].stack != "medium" || diffs[2].stack != "small" {
+		t.Fatalf("got %+v, want biggest inuseBytes growth first", diffs)
+	}
+}
+
+func TestWriteHeapDiffFirstSnapshotHasNoDiff(t *testing.T) {
+	sink := newMemSink()
+	p := newTestProfiler(sink)
+
+	if err := p.writeHeapDiff(); err != nil {
+		t.Fatal(err)
+	}
+	if p.prevHeap == nil {
+		t.Fatal("expected prevHeap to be set after the first snapshot")
+	}
+	if _, ok := sink.buf["mem-diff"]; ok {
+		t.Fatal("expected no mem-diff to be written on the first snapshot")
+	}
+}
+
+func TestWriteHeapDiffSecondSnapshotWritesDiff(t *testing.T) {
+	sink := newMemSink()
+	p := newTestProfiler(sink)
+
+	if err := p.writeHeapDiff(); err != nil {
+		t.Fatal(err)
+	}
+
+	// force a new call site to show up in the second snapshot
+	leak := make([][]byte, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		leak = append(leak, make([]byte, 1024))
+	}
+
+	if err := p.writeHeapDiff(); err != nil {
+		t.Fatal(err)
+	}
+	_ = leak
+
+	if _, ok := sink.buf["mem-diff"]; !ok {
+		t.Fatal("expected a mem-diff snapshot to be written on the second snapshot")
+	}
+	if p.prevHeap == nil {
+		t.Fatal("expected prevHeap to be updated to the latest heap samples")
+	}
+}
@@ -1,11 +1,16 @@
 package profiler
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,37 +26,81 @@ type Config struct {
 	MemoryProfileRate    int    `json:"memory_profile_rate"`    // set to 1 to include every allocated block in the profile, 0 to disable
 	CPUProfileRate       int    `json:"cpu_profile_rate"`       // set to a value above zero to enable collection (hz samples per second)
 	MutexProfileFraction int    `json:"mutex_profile_fraction"` // set to a value above zero to enable collection
+	HandleSignals        bool   `json:"handle_signals"`         // install a signal handler to start/stop snapshotting and flush on exit
+	Trace                bool   `json:"trace"`                  // enable runtime/trace execution tracing
+	HTTPAddr             string `json:"http_addr"`              // if set, serve on-demand profiles at /debug/pprof/* on this address
+	MaxSnapshots         int    `json:"max_snapshots"`          // if set, retain at most this many on-disk snapshots per profile kind, pruning oldest first; only honored by FileSink, a no-op for every other Sink
+	HeapDiff             bool   `json:"heap_diff"`              // if set, also write a mem-diff snapshot computed against the previous heap profile
 }
 
 // profiler is unexported to force initialisation via constructor
 type profiler struct {
-	conf        Config
-	terminateCh chan struct{}
-	closers     []func()
-	logger      *log.Logger
+	conf          Config
+	mu            sync.Mutex // guards terminateCh, runDone and the snapshot state below, all replaced on every Run so Stop can be called more than once
+	terminateCh   chan struct{}
+	runDone       chan struct{} // closed when Run returns, so callers can wait for its final flush before exiting
+	closers       []func()
+	logger        *log.Logger
+	started       int32 // accessed atomically, guards against a concurrent/double Run
+	sink          Sink
+	httpServer    *http.Server   // the on-demand profiling server, if Config.HTTPAddr is set
+	cpuWriter     io.WriteCloser // the currently open CPU profile, if CPU profiling is continuous
+	traceWriter   io.WriteCloser // the currently open trace, if tracing is continuous
+	cpuOnDemand   bool           // true while an HTTP request owns the process-wide CPU profiler
+	traceOnDemand bool           // true while an HTTP request owns the process-wide execution tracer
+	prevHeap      []heapSample   // previous heap profile samples, kept for Config.HeapDiff
 }
 
 // NewProfiler initialises a new instance of a profiler
-func NewProfiler(conf Config) *profiler {
-	return &profiler{
+func NewProfiler(conf Config, opts ...Option) *profiler {
+	p := &profiler{
 		conf:        conf,
 		terminateCh: make(chan struct{}),
 		closers:     make([]func(), 0),
 		logger:      log.New(os.Stdout, "[profiler] ", log.Ldate|log.Ltime),
+		sink:        FileSink{Prefix: conf.Prefix},
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if conf.HandleSignals {
+		p.handleSignals()
+	}
+	return p
 }
 
 func (c Config) isOn() bool {
-	return c.CPU || c.Memory || c.Goroutine || c.Block || c.Mutex
+	return c.CPU || c.Memory || c.Goroutine || c.Block || c.Mutex || c.Trace
 }
 
 // Run starts the profiler
 func (p *profiler) Run() {
-	if p.conf.CPU {
-		if p.conf.CPUProfileRate > 0 {
-			runtime.SetCPUProfileRate(p.conf.CPUProfileRate)
-		}
-		p.startProfilingCPU()
+	if !atomic.CompareAndSwapInt32(&p.started, 0, 1) {
+		p.logger.Println("profiler already running, ignoring Run()")
+		return
+	}
+
+	p.mu.Lock()
+	p.terminateCh = make(chan struct{})
+	terminateCh := p.terminateCh
+	p.runDone = make(chan struct{})
+	runDone := p.runDone
+	p.mu.Unlock()
+	defer func() {
+		atomic.StoreInt32(&p.started, 0)
+		close(runDone)
+	}()
+
+	if "" != p.conf.HTTPAddr {
+		p.startHTTPServer()
+	}
+
+	if p.conf.CPU && p.conf.CPUProfileRate > 0 {
+		runtime.SetCPUProfileRate(p.conf.CPUProfileRate)
+	}
+	if err := p.startContinuousProfiles(); err != nil {
+		p.logger.Println(err)
+		return
 	}
 	if p.conf.Memory {
 		runtime.MemProfileRate = p.conf.MemoryProfileRate
@@ -69,137 +118,254 @@ func (p *profiler) Run() {
 			log.Println("Error parsing interval parameter:", err)
 			return
 		}
-		timer := time.NewTimer(interval)
-		select {
-		case <-timer.C:
-			p.TakeSnapshot()
-			// start again
-			p.Run()
-		case <-p.terminateCh:
-			p.TakeSnapshot()
+		for {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+				// roll continuous profiles (CPU, trace) onto a fresh file with no gap in samples
+				if err := p.takeSnapshot(true); err != nil {
+					p.logger.Println(err)
+				}
+			case <-terminateCh:
+				timer.Stop()
+				if err := p.takeSnapshot(false); err != nil {
+					p.logger.Println(err)
+				}
+				return
+			}
 		}
 	}
 }
 
-// TakeSnapshot takes a profiling data snapshot for the enabled resources
-func (p *profiler) TakeSnapshot() {
+// TakeSnapshot takes a profiling data snapshot for the enabled resources,
+// returning any errors encountered along the way. Continuous profiles
+// (CPU, trace) roll onto a fresh file with no gap between samples; call
+// Stop to end continuous profiling for good.
+func (p *profiler) TakeSnapshot() error {
+	return p.takeSnapshot(true)
+}
+
+// takeSnapshot is the shared implementation behind TakeSnapshot and the
+// final flush on shutdown; continueProfiling controls whether continuous
+// profiles (CPU, trace) are restarted once the current file is finalised.
+// It holds p.mu for its whole body, since it reads and writes cpuWriter,
+// traceWriter, prevHeap and closers, and can otherwise run concurrently
+// with Run's own interval loop.
+func (p *profiler) takeSnapshot(continueProfiling bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
 	if p.conf.CPU {
-		p.takeCPUSnapshot()
+		if err := p.takeCPUSnapshot(continueProfiling); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if p.conf.Trace {
+		if err := p.takeTraceSnapshot(continueProfiling); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if p.conf.Memory {
-		p.takeMemorySnapshot()
+		if err := p.takeMemorySnapshot(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if p.conf.Block {
-		p.takeBlockSnapshot()
+		if err := p.takeBlockSnapshot(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if p.conf.Goroutine {
-		p.takeGoroutineSnapshot()
+		if err := p.takeGoroutineSnapshot(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 	if p.conf.Mutex {
-		p.takeMutexSnapshot()
+		if err := p.takeMutexSnapshot(); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	for _, c := range p.closers {
 		c()
 	}
 	p.closers = p.closers[:0]
-}
 
-// Stop terminates the active profiler(s)
-func (p profiler) Stop() {
-	close(p.terminateCh)
+	return errors.Join(errs...)
 }
 
-// opens a new output file to collect CPU profiling information
-func (p *profiler) startProfilingCPU() {
-	pprofFile := fmt.Sprintf("%scpu.%d.pprof", p.conf.Prefix, time.Now().Unix())
-	p.logger.Println("Starting new CPU Profiler:", pprofFile)
-	f, err := os.Create(pprofFile)
-	if err != nil {
-		panic(err)
-	}
-	if err = pprof.StartCPUProfile(f); err != nil {
-		p.logger.Println("could not start CPU profile: ", err)
+// Stop terminates the active profiler(s). It is safe to call more than
+// once, and Run can be called again afterwards to restart the profiler.
+func (p *profiler) Stop() {
+	p.mu.Lock()
+	select {
+	case <-p.terminateCh:
+		// already stopped
+	default:
+		close(p.terminateCh)
 	}
-	p.closers = append(p.closers, func() {
-		if err = f.Close(); err != nil {
+	server := p.httpServer
+	p.httpServer = nil
+	p.mu.Unlock()
+
+	if server != nil {
+		if err := shutdownHTTPServer(server); err != nil {
 			p.logger.Println(err)
 		}
-	})
+	}
 }
 
-// collect CPU profiling information
-func (p profiler) takeCPUSnapshot() {
-	p.logger.Println("Stopping CPU Profiler")
-	pprof.StopCPUProfile()
+// waitDone blocks until the active Run call has returned, flushing its
+// final snapshot; it returns immediately if Run has never been called.
+// Callers that need the in-flight CPU/trace profile on disk before
+// exiting the process should call Stop followed by waitDone.
+func (p *profiler) waitDone() {
+	p.mu.Lock()
+	runDone := p.runDone
+	p.mu.Unlock()
+	if runDone != nil {
+		<-runDone
+	}
+}
+
+// startContinuousProfiles starts CPU and/or trace sampling under p.mu, so
+// it cannot race a concurrent takeSnapshot over cpuWriter/traceWriter
+func (p *profiler) startContinuousProfiles() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conf.CPU {
+		if err := p.startProfilingCPU(); err != nil {
+			return err
+		}
+	}
+	if p.conf.Trace {
+		if err := p.startTrace(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// collect Memory profiling information
-func (p profiler) takeMemorySnapshot() {
-	pprofFile := fmt.Sprintf("%smem.%d.pprof", p.conf.Prefix, time.Now().Unix())
-	p.logger.Println("Taking Memory Profile Snapshot:", pprofFile)
-	f, err := os.Create(pprofFile)
+// opens a new output file and starts sampling CPU profiling information
+// into it; the caller must hold p.mu. Returns an error without touching
+// the process-wide CPU profiler if an on-demand capture currently owns it.
+func (p *profiler) startProfilingCPU() error {
+	if p.cpuOnDemand {
+		return errors.New("cpu profile: on-demand capture in progress, skipping continuous start")
+	}
+	p.logger.Println("Starting new CPU Profiler")
+	f, err := p.sink.NewWriter("cpu", time.Now())
 	if err != nil {
-		p.logger.Println(err)
+		return fmt.Errorf("cpu profile: %w", err)
 	}
+	if err = pprof.StartCPUProfile(f); err != nil {
+		return fmt.Errorf("cpu profile: could not start: %w", err)
+	}
+	p.cpuWriter = f
+	return nil
+}
 
-	if err = pprof.WriteHeapProfile(f); err != nil {
-		p.logger.Println(err)
+// finalises the current CPU profile file; when continueProfiling is true a
+// new file is opened and sampling resumes immediately, so no CPU samples
+// are lost between two consecutive snapshots. The caller must hold p.mu.
+// If an on-demand capture currently owns the process-wide CPU profiler
+// (p.cpuWriter is nil because startProfilingCPU was refused above), this
+// is a no-op rather than stopping someone else's capture.
+func (p *profiler) takeCPUSnapshot(continueProfiling bool) error {
+	if p.cpuWriter == nil {
+		if p.cpuOnDemand {
+			return errors.New("cpu profile: on-demand capture in progress, skipping rotation")
+		}
+		return nil
 	}
-	if err = f.Close(); err != nil {
-		p.logger.Println(err)
+	p.logger.Println("Stopping CPU Profiler")
+	pprof.StopCPUProfile()
+	var errs []error
+	if err := p.cpuWriter.Close(); err != nil {
+		errs = append(errs, err)
 	}
+	p.cpuWriter = nil
+	p.pruneSnapshots("cpu")
+	if continueProfiling {
+		if err := p.startProfilingCPU(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // collect Block profiling information
-func (p profiler) takeBlockSnapshot() {
-	pprofFile := fmt.Sprintf("%sblock.%d.pprof", p.conf.Prefix, time.Now().Unix())
-	p.logger.Println("Taking Block Profile Snapshot:", pprofFile)
-	f, err := os.Create(pprofFile)
+func (p *profiler) takeBlockSnapshot() error {
+	p.logger.Println("Taking Block Profile Snapshot")
+	f, err := p.sink.NewWriter("block", time.Now())
 	if err != nil {
-		p.logger.Println(err)
+		return fmt.Errorf("block profile: %w", err)
 	}
-	profile := pprof.Lookup("block")
-	if err = profile.WriteTo(f, 2); err != nil {
-		p.logger.Println(err)
+	var errs []error
+	if err := pprof.Lookup("block").WriteTo(f, 2); err != nil {
+		errs = append(errs, err)
 	}
-
-	if err = f.Close(); err != nil {
-		p.logger.Println(err)
+	if err := f.Close(); err != nil {
+		errs = append(errs, err)
 	}
 	runtime.SetBlockProfileRate(0)
+	p.pruneSnapshots("block")
+	return errors.Join(errs...)
 }
 
 // collect Goroutine profiling information
-func (p profiler) takeGoroutineSnapshot() {
-	pprofFile := fmt.Sprintf("%sgoroutine.%d.pprof", p.conf.Prefix, time.Now().Unix())
-	p.logger.Println("Taking Goroutine Profile Snapshot:", pprofFile)
-	f, err := os.Create(pprofFile)
+func (p *profiler) takeGoroutineSnapshot() error {
+	p.logger.Println("Taking Goroutine Profile Snapshot")
+	f, err := p.sink.NewWriter("goroutine", time.Now())
 	if err != nil {
-		p.logger.Println(err)
+		return fmt.Errorf("goroutine profile: %w", err)
 	}
-	profile := pprof.Lookup("goroutine")
-	if err = profile.WriteTo(f, 2); err != nil {
-		p.logger.Println(err)
+	var errs []error
+	if err := pprof.Lookup("goroutine").WriteTo(f, 2); err != nil {
+		errs = append(errs, err)
 	}
-	if err = f.Close(); err != nil {
-		p.logger.Println(err)
+	if err := f.Close(); err != nil {
+		errs = append(errs, err)
 	}
+	p.pruneSnapshots("goroutine")
+	return errors.Join(errs...)
 }
 
 // collect Mutex profiling information
-func (p profiler) takeMutexSnapshot() {
-	pprofFile := fmt.Sprintf("%smutex.%d.pprof", p.conf.Prefix, time.Now().Unix())
-	p.logger.Println("Taking Mutex Profile Snapshot:", pprofFile)
-	f, err := os.Create(pprofFile)
+func (p *profiler) takeMutexSnapshot() error {
+	p.logger.Println("Taking Mutex Profile Snapshot")
+	f, err := p.sink.NewWriter("mutex", time.Now())
 	if err != nil {
-		p.logger.Println(err)
+		return fmt.Errorf("mutex profile: %w", err)
 	}
-	profile := pprof.Lookup("mutex")
-	if err = profile.WriteTo(f, 2); err != nil {
-		p.logger.Println(err)
+	var errs []error
+	if err := pprof.Lookup("mutex").WriteTo(f, 2); err != nil {
+		errs = append(errs, err)
+	}
+	if err := f.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	p.pruneSnapshots("mutex")
+	return errors.Join(errs...)
+}
+
+// pruneSnapshots removes the oldest on-disk snapshots of kind, keeping at
+// most Config.MaxSnapshots; it only applies to FileSink. RotatingDirSink
+// has its own independent retention (MaxPerKind, enforced on every write)
+// and ignores Config.MaxSnapshots entirely; S3Sink, GCSSink and HTTPSink
+// have no retention logic at all, so Config.MaxSnapshots is silently a
+// no-op for them and snapshots accumulate unbounded at the destination.
+func (p *profiler) pruneSnapshots(kind string) {
+	if p.conf.MaxSnapshots <= 0 {
+		return
+	}
+	fs, ok := p.sink.(FileSink)
+	if !ok {
+		return
 	}
-	if err = f.Close(); err != nil {
+	if err := pruneFileSinkSnapshots(fs, kind, p.conf.MaxSnapshots); err != nil {
 		p.logger.Println(err)
 	}
 }
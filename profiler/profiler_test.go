@@ -0,0 +1,52 @@
+package profiler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStopIsIdempotent(t *testing.T) {
+	p := NewProfiler(Config{})
+	p.Stop()
+	p.Stop() // must not panic or block on an already-closed terminateCh
+}
+
+func TestRunIgnoresConcurrentCall(t *testing.T) {
+	p := NewProfiler(Config{Memory: true, Interval: "10ms"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.Run()
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	p.Run() // should return immediately, started is already 1
+
+	p.Stop()
+	p.waitDone()
+	wg.Wait()
+}
+
+func TestRunIsRestartable(t *testing.T) {
+	dir := t.TempDir()
+	p := NewProfiler(Config{Memory: true, Interval: "10ms"}, func(p *profiler) {
+		p.sink = FileSink{Prefix: dir + "/"}
+	})
+
+	for i := 0; i < 2; i++ {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run()
+		}()
+		time.Sleep(15 * time.Millisecond)
+
+		p.Stop()
+		p.waitDone()
+		wg.Wait()
+	}
+}
@@ -0,0 +1,47 @@
+package profiler
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleSignals installs a signal handler goroutine that lets an operator
+// drive the profiler interactively: SIGUSR1 starts snapshotting, SIGUSR2
+// stops it, and SIGINT/SIGTERM flush any in-flight CPU profile and write a
+// final heap dump before the process is allowed to exit.
+func (p *profiler) handleSignals() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		for sig := range sigCh {
+			switch sig {
+			case syscall.SIGUSR1:
+				p.logger.Println("received SIGUSR1, starting profiler")
+				go p.Run()
+			case syscall.SIGUSR2:
+				p.logger.Println("received SIGUSR2, stopping profiler")
+				p.Stop()
+			case syscall.SIGINT, syscall.SIGTERM:
+				p.logger.Println("received", sig, "- flushing profiles before exit")
+				p.Stop()
+				p.waitDone()
+				p.mu.Lock()
+				// Run only flushes from its interval loop; finalise anything still open
+				if err := p.takeCPUSnapshot(false); err != nil {
+					p.logger.Println(err)
+				}
+				if err := p.takeTraceSnapshot(false); err != nil {
+					p.logger.Println(err)
+				}
+				if err := p.takeMemorySnapshot(); err != nil {
+					p.logger.Println(err)
+				}
+				p.mu.Unlock()
+				signal.Stop(sigCh)
+				os.Exit(0)
+			}
+		}
+	}()
+}
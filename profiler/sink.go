@@ -0,0 +1,55 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Sink abstracts where profiling snapshots are written to, decoupling
+// snapshot-taking from local disk so that long-running services can ship
+// profiles to object storage (or anywhere else) instead of filling up
+// local disk with files nobody collects.
+type Sink interface {
+	// NewWriter opens a new destination for a profile of the given kind
+	// (e.g. "cpu", "mem", "block", "goroutine", "mutex", "trace") taken
+	// at ts. The caller closes the writer once the snapshot is written.
+	NewWriter(kind string, ts time.Time) (io.WriteCloser, error)
+}
+
+// Option configures a profiler at construction time
+type Option func(*profiler)
+
+// WithSink overrides the profiler's default FileSink with a custom Sink,
+// e.g. to ship snapshots to S3/GCS instead of the local filesystem
+func WithSink(sink Sink) Option {
+	return func(p *profiler) {
+		p.sink = sink
+	}
+}
+
+// FileSink writes profile snapshots to local files named
+// "${Prefix}<kind>.<unix>.<ext>", matching the module's original behaviour
+type FileSink struct {
+	Prefix string
+}
+
+// NewWriter creates the local snapshot file for the given profile kind
+func (s FileSink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	return os.Create(fmt.Sprintf("%s%s.%d.%s", s.Prefix, kind, ts.Unix(), extensionFor(kind)))
+}
+
+// extensionFor returns the conventional file extension for a profile kind;
+// execution traces are consumed by `go tool trace`, mem-diff is a
+// plain-text report (see writeHeapDiffReport), everything else by pprof
+func extensionFor(kind string) string {
+	switch kind {
+	case "trace":
+		return "out"
+	case "mem-diff":
+		return "txt"
+	default:
+		return "pprof"
+	}
+}
@@ -0,0 +1,28 @@
+//go:build gcs
+
+package profiler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// GCSSink uploads profile snapshots to a Google Cloud Storage bucket
+// instead of local disk, for services that want continuous profiling
+// shipped to object storage
+type GCSSink struct {
+	Client *storage.Client
+	Bucket string
+	Prefix string
+}
+
+// NewWriter returns the GCS object writer for "${Prefix}<kind>.<unix>.<ext>";
+// the object is finalised when the caller closes it
+func (s GCSSink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	key := fmt.Sprintf("%s%s.%d.%s", s.Prefix, kind, ts.Unix(), extensionFor(kind))
+	return s.Client.Bucket(s.Bucket).Object(key).NewWriter(context.Background()), nil
+}
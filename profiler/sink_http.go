@@ -0,0 +1,47 @@
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSink POSTs profile snapshots to a collector endpoint instead of
+// writing them to local disk, e.g. for shipping to an internal profile store
+type HTTPSink struct {
+	Client *http.Client // optional, defaults to http.DefaultClient
+	URL    string       // base URL the snapshot is POSTed to, kind and timestamp are appended as query params
+}
+
+// NewWriter returns a writer that buffers the snapshot in memory and POSTs
+// it to s.URL, with kind, ts and ext query params, on Close
+func (s HTTPSink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpWriter{
+		client: client,
+		url:    fmt.Sprintf("%s?kind=%s&ts=%d&ext=%s", s.URL, kind, ts.Unix(), extensionFor(kind)),
+	}, nil
+}
+
+type httpWriter struct {
+	client *http.Client
+	url    string
+	buf    bytes.Buffer
+}
+
+func (w *httpWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *httpWriter) Close() error {
+	resp, err := w.client.Post(w.url, "application/octet-stream", &w.buf)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
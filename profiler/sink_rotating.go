@@ -0,0 +1,80 @@
+package profiler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// RotatingDirSink writes snapshots to a directory like FileSink, but prunes
+// older files in FIFO order so at most MaxPerKind are retained for each
+// profile kind, preventing long-running services from filling local disk
+type RotatingDirSink struct {
+	Dir        string
+	MaxPerKind int
+}
+
+// NewWriter creates the snapshot file and prunes stale ones of the same
+// kind once it is closed
+func (s RotatingDirSink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	path := filepath.Join(s.Dir, fmt.Sprintf("%s.%d.%s", kind, ts.Unix(), extensionFor(kind)))
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &rotatingWriter{File: f, sink: s, kind: kind}, nil
+}
+
+type rotatingWriter struct {
+	*os.File
+	sink RotatingDirSink
+	kind string
+}
+
+func (w *rotatingWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	return w.sink.prune(w.kind)
+}
+
+// prune removes the oldest snapshots of kind until at most MaxPerKind remain
+func (s RotatingDirSink) prune(kind string) error {
+	if s.MaxPerKind <= 0 {
+		return nil
+	}
+	return pruneFIFO(s.Dir, fmt.Sprintf("%s.*.%s", kind, extensionFor(kind)), s.MaxPerKind)
+}
+
+// pruneFileSinkSnapshots removes the oldest on-disk snapshots of kind
+// written by a FileSink, keeping at most max
+func pruneFileSinkSnapshots(s FileSink, kind string, max int) error {
+	dir, prefix := filepath.Split(s.Prefix)
+	if dir == "" {
+		dir = "."
+	}
+	return pruneFIFO(dir, fmt.Sprintf("%s%s.*.%s", prefix, kind, extensionFor(kind)), max)
+}
+
+// pruneFIFO keeps at most max files matching pattern inside dir, removing
+// the oldest first; filenames are expected to embed a unix timestamp, so
+// lexical order matches chronological order
+func pruneFIFO(dir, pattern string, max int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return err
+	}
+	if len(matches) <= max {
+		return nil
+	}
+	sort.Strings(matches)
+	for _, stale := range matches[:len(matches)-max] {
+		if err := os.Remove(stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
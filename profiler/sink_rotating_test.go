@@ -0,0 +1,72 @@
+package profiler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneFIFOKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"cpu.100.pprof", "cpu.200.pprof", "cpu.300.pprof", "cpu.400.pprof"} {
+		if err := os.WriteFile(filepath.Join(dir, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneFIFO(dir, "cpu.*.pprof", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := filepath.Glob(filepath.Join(dir, "cpu.*.pprof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"cpu.300.pprof": true, "cpu.400.pprof": true}
+	if len(remaining) != len(want) {
+		t.Fatalf("got %v, want the 2 newest files", remaining)
+	}
+	for _, r := range remaining {
+		if !want[filepath.Base(r)] {
+			t.Fatalf("unexpected file kept: %s", r)
+		}
+	}
+}
+
+func TestPruneFIFONoopUnderLimit(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"cpu.100.pprof", "cpu.200.pprof"} {
+		if err := os.WriteFile(filepath.Join(dir, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneFIFO(dir, "cpu.*.pprof", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := filepath.Glob(filepath.Join(dir, "cpu.*.pprof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("got %d files, want both kept since count is under max", len(remaining))
+	}
+}
+
+func TestPruneFIFOIgnoresOtherKinds(t *testing.T) {
+	dir := t.TempDir()
+	for _, n := range []string{"cpu.100.pprof", "cpu.200.pprof", "mem.100.pprof"} {
+		if err := os.WriteFile(filepath.Join(dir, n), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pruneFIFO(dir, "cpu.*.pprof", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "mem.100.pprof")); err != nil {
+		t.Fatalf("expected mem.100.pprof to be left untouched: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build s3
+
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Sink uploads profile snapshots to an S3 bucket instead of local disk,
+// for services that want continuous profiling without filling local disk
+type S3Sink struct {
+	Uploader *s3manager.Uploader
+	Bucket   string
+	Prefix   string
+}
+
+// NewWriter returns a writer that buffers the snapshot in memory and
+// uploads it to S3 as "${Prefix}<kind>.<unix>.<ext>" on Close
+func (s S3Sink) NewWriter(kind string, ts time.Time) (io.WriteCloser, error) {
+	key := fmt.Sprintf("%s%s.%d.%s", s.Prefix, kind, ts.Unix(), extensionFor(kind))
+	return &s3Writer{uploader: s.Uploader, bucket: s.Bucket, key: key}, nil
+}
+
+type s3Writer struct {
+	uploader *s3manager.Uploader
+	bucket   string
+	key      string
+	buf      bytes.Buffer
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	_, err := w.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(w.bucket),
+		Key:    aws.String(w.key),
+		Body:   bytes.NewReader(w.buf.Bytes()),
+	})
+	return err
+}
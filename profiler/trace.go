@@ -0,0 +1,56 @@
+package profiler
+
+import (
+	"errors"
+	"fmt"
+	"runtime/trace"
+	"time"
+)
+
+// opens a new output file and starts the runtime execution tracer; it
+// captures scheduler, GC and syscall events that CPU sampling misses.
+// The caller must hold p.mu. Returns an error without touching the
+// process-wide tracer if an on-demand capture currently owns it.
+func (p *profiler) startTrace() error {
+	if p.traceOnDemand {
+		return errors.New("trace: on-demand capture in progress, skipping continuous start")
+	}
+	p.logger.Println("Starting new execution Tracer")
+	f, err := p.sink.NewWriter("trace", time.Now())
+	if err != nil {
+		return fmt.Errorf("trace: %w", err)
+	}
+	if err = trace.Start(f); err != nil {
+		return fmt.Errorf("trace: could not start: %w", err)
+	}
+	p.traceWriter = f
+	return nil
+}
+
+// finalises the current trace file; when continueProfiling is true a new
+// file is opened and tracing resumes immediately, mirroring the CPU
+// profiler. The caller must hold p.mu. If an on-demand capture currently
+// owns the tracer (p.traceWriter is nil because startTrace was refused
+// above), this is a no-op rather than stopping someone else's capture.
+func (p *profiler) takeTraceSnapshot(continueProfiling bool) error {
+	if p.traceWriter == nil {
+		if p.traceOnDemand {
+			return errors.New("trace: on-demand capture in progress, skipping rotation")
+		}
+		return nil
+	}
+	p.logger.Println("Stopping execution Tracer")
+	trace.Stop()
+	var errs []error
+	if err := p.traceWriter.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	p.traceWriter = nil
+	p.pruneSnapshots("trace")
+	if continueProfiling {
+		if err := p.startTrace(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}